@@ -0,0 +1,101 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"fmt"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// ResolveEffectiveAccessMode determines the access mode a VMI's volume actually gets at
+// attach time, as opposed to the access mode merely requested on the PVC spec. It reads
+// the bound PV and intersects its AccessModes with the PVC's (a PV may bind with a
+// subset of the requested modes). migratable reports whether a VMI using this volume can
+// safely be live-migrated, which requires ReadWriteMany: Kubernetes will not bind a PVC
+// as ReadWriteMany unless the provisioner (or, for statically provisioned PVs, whoever
+// created the PV) actually supports attaching it to more than one node at once, so RWX
+// itself is the multi-node attach signal. The CSIDriver object has no field exposing that
+// capability directly — VOLUME_ACCESSIBILITY_CONSTRAINTS/MULTI_NODE support is negotiated
+// between external-attacher and the driver's ControllerGetCapabilities RPC and never
+// surfaced on the Kubernetes object — so the CSIDriver lookup below only confirms a CSI
+// plugin for the PV's driver is actually installed, bailing out conservatively if not.
+func ResolveEffectiveAccessMode(ctx context.Context, client kubecli.KubevirtClient, pvc *k8sv1.PersistentVolumeClaim) (mode k8sv1.PersistentVolumeAccessMode, migratable bool, err error) {
+	if pvc.Spec.VolumeName == "" {
+		return "", false, fmt.Errorf("PVC %s/%s is not bound to a PV yet", pvc.Namespace, pvc.Name)
+	}
+	pv, err := client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, v1.GetOptions{})
+	if err != nil {
+		return "", false, err
+	}
+
+	mode = intersectAccessMode(pvc.Spec.AccessModes, pv.Spec.AccessModes)
+	if mode != k8sv1.ReadWriteMany {
+		return mode, false, nil
+	}
+
+	if pv.Spec.CSI == nil {
+		// Non-CSI RWX volumes (e.g. NFS) don't gate on driver capabilities.
+		return mode, true, nil
+	}
+
+	_, err = client.StorageV1().CSIDrivers().Get(ctx, pv.Spec.CSI.Driver, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		// Unknown driver: be conservative and assume it cannot be safely migrated.
+		return mode, false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return mode, true, nil
+}
+
+// intersectAccessMode returns an access mode that both the PVC's requested modes and the
+// bound PV's actual modes agree on: ReadWriteMany when both sides declare it, otherwise
+// the last PVC-requested mode the PV also declares, so that an RWX-requested-but-RWO-bound
+// claim is correctly reported as RWO rather than RWX. When the two sides share no mode,
+// it returns the PV's first declared mode, or ReadWriteOnce if the PV declares none.
+func intersectAccessMode(pvcModes, pvModes []k8sv1.PersistentVolumeAccessMode) k8sv1.PersistentVolumeAccessMode {
+	pvHas := map[k8sv1.PersistentVolumeAccessMode]bool{}
+	for _, m := range pvModes {
+		pvHas[m] = true
+	}
+	best := k8sv1.ReadWriteOnce
+	found := false
+	for _, m := range pvcModes {
+		if !pvHas[m] {
+			continue
+		}
+		found = true
+		if m == k8sv1.ReadWriteMany {
+			return k8sv1.ReadWriteMany
+		}
+		best = m
+	}
+	if !found && len(pvModes) > 0 {
+		return pvModes[0]
+	}
+	return best
+}
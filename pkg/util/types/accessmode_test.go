@@ -0,0 +1,68 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+func TestIntersectAccessMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		pvcModes []k8sv1.PersistentVolumeAccessMode
+		pvModes  []k8sv1.PersistentVolumeAccessMode
+		want     k8sv1.PersistentVolumeAccessMode
+	}{
+		{
+			name:     "RWX requested and bound",
+			pvcModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteMany},
+			pvModes:  []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce, k8sv1.ReadWriteMany},
+			want:     k8sv1.ReadWriteMany,
+		},
+		{
+			name:     "RWX requested but PV only bound RWO",
+			pvcModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteMany},
+			pvModes:  []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce},
+			want:     k8sv1.ReadWriteOnce,
+		},
+		{
+			name:     "RWO requested and bound",
+			pvcModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce},
+			pvModes:  []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce, k8sv1.ReadWriteMany},
+			want:     k8sv1.ReadWriteOnce,
+		},
+		{
+			name:     "PVC requests modes the PV doesn't declare",
+			pvcModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteMany},
+			pvModes:  []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadOnlyMany},
+			want:     k8sv1.ReadOnlyMany,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := intersectAccessMode(tt.pvcModes, tt.pvModes); got != tt.want {
+				t.Fatalf("intersectAccessMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"fmt"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	virtv1 "kubevirt.io/api/core/v1"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// VMIProtectionFinalizer mirrors kubernetes.io/pvc-protection: it is added to a PVC
+// while at least one VMI or VM still references it, and keeps Kubernetes from deleting
+// the claim out from under a running VMI.
+const VMIProtectionFinalizer string = "kubevirt.io/vmi-protection"
+
+// VMIByReferencedPVCIndex indexes VMIs, and VMByReferencedPVCIndex indexes VMs, by the
+// namespace/claimName of every PVC-backed volume they declare (see
+// VMIByReferencedPVCIndexFunc and VMByReferencedPVCIndexFunc), so
+// RemoveVMIProtectionFinalizer can answer "does any VMI or VM still reference this PVC"
+// from the local caches instead of listing every VMI/VM in the namespace. A stopped VM
+// has no VMI at all, so the PVC still needs protecting as long as its owning VM exists:
+// checking only VMIs would drop protection for exactly the common stop/start workflow.
+const (
+	VMIByReferencedPVCIndex = "by-referenced-pvc"
+	VMByReferencedPVCIndex  = "by-referenced-pvc"
+)
+
+// VerifyVMIReferenceIndexer checks that vmiInformer already has VMIByReferencedPVCIndex
+// registered (with VMIByReferencedPVCIndexFunc, at informer-factory construction time),
+// returning an error if not. Like VolumeResolver's indexers, it only verifies and never
+// registers: SharedIndexInformer.AddIndexers errors out once the informer has started,
+// which is typically before any consumer of this package gets a handle on it.
+func VerifyVMIReferenceIndexer(vmiInformer cache.SharedIndexInformer) error {
+	return verifyReferenceIndexer(vmiInformer, VMIByReferencedPVCIndex)
+}
+
+// VerifyVMReferenceIndexer is VerifyVMIReferenceIndexer's counterpart for the VM
+// informer, checking for VMByReferencedPVCIndex (registered with
+// VMByReferencedPVCIndexFunc).
+func VerifyVMReferenceIndexer(vmInformer cache.SharedIndexInformer) error {
+	return verifyReferenceIndexer(vmInformer, VMByReferencedPVCIndex)
+}
+
+func verifyReferenceIndexer(informer cache.SharedIndexInformer, name string) error {
+	if _, ok := informer.GetIndexer().GetIndexers()[name]; ok {
+		return nil
+	}
+	return fmt.Errorf("informer is missing required indexer %q; it must be registered before the informer is started", name)
+}
+
+// VMIByReferencedPVCIndexFunc returns one "namespace/claimName" index key per PVC-backed
+// volume a VMI declares, covering both direct PersistentVolumeClaim volumes and
+// DataVolume volumes (which materialize into a PVC of the same name).
+func VMIByReferencedPVCIndexFunc(obj interface{}) ([]string, error) {
+	vmi, ok := obj.(*virtv1.VirtualMachineInstance)
+	if !ok {
+		return nil, fmt.Errorf("object is not a VMI: %v", obj)
+	}
+	return referencedPVCKeys(vmi.Namespace, vmi.Spec.Volumes), nil
+}
+
+// VMByReferencedPVCIndexFunc returns one "namespace/claimName" index key per PVC-backed
+// volume declared in a VM's instance template, the same way VMIByReferencedPVCIndexFunc
+// does for a running VMI. A VM with no template (not yet reconciled) references nothing.
+func VMByReferencedPVCIndexFunc(obj interface{}) ([]string, error) {
+	vm, ok := obj.(*virtv1.VirtualMachine)
+	if !ok {
+		return nil, fmt.Errorf("object is not a VM: %v", obj)
+	}
+	if vm.Spec.Template == nil {
+		return nil, nil
+	}
+	return referencedPVCKeys(vm.Namespace, vm.Spec.Template.Spec.Volumes), nil
+}
+
+func referencedPVCKeys(namespace string, volumes []virtv1.Volume) []string {
+	keys := make([]string, 0, len(volumes))
+	for _, vol := range volumes {
+		claimName := volumePVCName(vol)
+		if claimName == "" {
+			continue
+		}
+		keys = append(keys, namespace+"/"+claimName)
+	}
+	return keys
+}
+
+func volumePVCName(vol virtv1.Volume) string {
+	switch {
+	case vol.PersistentVolumeClaim != nil:
+		return vol.PersistentVolumeClaim.ClaimName
+	case vol.DataVolume != nil:
+		return vol.DataVolume.Name
+	default:
+		return ""
+	}
+}
+
+// HasVMIReference reports whether any VMI other than excludeVMIKey (a "namespace/name"
+// key, typically the VMI whose deletion triggered the check; pass "" to not exclude any)
+// still references the PVC namespace/claimName, or any VM at all does (a VM is never
+// excluded here: the workflow this guards against is a VM's own VMI being deleted on
+// stop, which must not unprotect a PVC the same VM will reference again on start).
+func HasVMIReference(vmiInformer, vmInformer cache.SharedIndexInformer, namespace, claimName, excludeVMIKey string) (bool, error) {
+	key := namespace + "/" + claimName
+
+	vmiObjs, err := vmiInformer.GetIndexer().ByIndex(VMIByReferencedPVCIndex, key)
+	if err != nil {
+		return false, err
+	}
+	for _, obj := range vmiObjs {
+		vmi, ok := obj.(*virtv1.VirtualMachineInstance)
+		if !ok {
+			return false, fmt.Errorf("object is not a VMI: %v", obj)
+		}
+		if vmi.Namespace+"/"+vmi.Name == excludeVMIKey {
+			continue
+		}
+		return true, nil
+	}
+
+	vmObjs, err := vmInformer.GetIndexer().ByIndex(VMByReferencedPVCIndex, key)
+	if err != nil {
+		return false, err
+	}
+	return len(vmObjs) > 0, nil
+}
+
+// IsProtectedByVMI reports whether pvc currently carries the VMIProtectionFinalizer.
+func IsProtectedByVMI(pvc *k8sv1.PersistentVolumeClaim) bool {
+	for _, f := range pvc.Finalizers {
+		if f == VMIProtectionFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// AddVMIProtectionFinalizer adds the VMIProtectionFinalizer to pvc if it is not already
+// present. It is a no-op if the finalizer is already set, so it is safe to call once per
+// VMI/VM that references the PVC without any reference counting of its own.
+func AddVMIProtectionFinalizer(client kubecli.KubevirtClient, pvc *k8sv1.PersistentVolumeClaim) error {
+	if IsProtectedByVMI(pvc) {
+		return nil
+	}
+	patched := pvc.DeepCopy()
+	patched.Finalizers = append(patched.Finalizers, VMIProtectionFinalizer)
+	_, err := client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(context.Background(), patched, v1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		// Someone else updated the PVC concurrently; the caller's informer will
+		// deliver the new version and the next reconcile will retry.
+		return nil
+	}
+	return err
+}
+
+// RemoveVMIProtectionFinalizer removes the VMIProtectionFinalizer from pvc, but only if
+// no VMI other than excludeVMIKey ("namespace/name", pass "" if none should be excluded)
+// and no VM still references it, per vmiInformer's VMIByReferencedPVCIndex and
+// vmInformer's VMByReferencedPVCIndex. Checking VMs too is what keeps a stopped VM's PVC
+// protected: stopping a VM deletes its VMI, but the VM itself still references the PVC
+// and will recreate the same VMI on next start. It is also what makes removal safe under
+// concurrent VMI deletion: if two VMIs reference the same PVC and one is deleted, its
+// reconcile sees the other VMI still indexed and leaves the finalizer in place, rather
+// than unprotecting a PVC a surviving VMI still needs. It is idempotent: if the finalizer
+// is already absent, the PVC is already gone, or another VMI/VM still references it, it
+// returns nil without modifying the PVC. A concurrent update losing the race
+// (errors.IsConflict) is likewise treated as a no-op: the caller's informer will deliver
+// the new version and the next reconcile re-evaluates the reference count.
+func RemoveVMIProtectionFinalizer(client kubecli.KubevirtClient, vmiInformer, vmInformer cache.SharedIndexInformer, pvc *k8sv1.PersistentVolumeClaim, excludeVMIKey string) error {
+	if !IsProtectedByVMI(pvc) {
+		return nil
+	}
+	referenced, err := HasVMIReference(vmiInformer, vmInformer, pvc.Namespace, pvc.Name, excludeVMIKey)
+	if err != nil {
+		return err
+	}
+	if referenced {
+		return nil
+	}
+	patched := pvc.DeepCopy()
+	finalizers := patched.Finalizers[:0]
+	for _, f := range patched.Finalizers {
+		if f != VMIProtectionFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	patched.Finalizers = finalizers
+	_, err = client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(context.Background(), patched, v1.UpdateOptions{})
+	if errors.IsConflict(err) || errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,347 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	k8sv1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	virtv1 "kubevirt.io/api/core/v1"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+func TestVMIByReferencedPVCIndexFunc(t *testing.T) {
+	vmi := &virtv1.VirtualMachineInstance{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "vmi1"},
+		Spec: virtv1.VirtualMachineInstanceSpec{
+			Volumes: []virtv1.Volume{
+				{
+					Name: "disk0",
+					VolumeSource: virtv1.VolumeSource{
+						PersistentVolumeClaim: &virtv1.PersistentVolumeClaimVolumeSource{
+							PersistentVolumeClaimVolumeSource: k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: "claim-a"},
+						},
+					},
+				},
+				{
+					Name: "disk1",
+					VolumeSource: virtv1.VolumeSource{
+						DataVolume: &virtv1.DataVolumeSource{Name: "dv-b"},
+					},
+				},
+				{
+					Name:         "disk2",
+					VolumeSource: virtv1.VolumeSource{ContainerDisk: &virtv1.ContainerDiskSource{Image: "example"}},
+				},
+			},
+		},
+	}
+
+	keys, err := VMIByReferencedPVCIndexFunc(vmi)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(keys)
+	want := []string{"default/claim-a", "default/dv-b"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+}
+
+func TestVMIByReferencedPVCIndexFuncRejectsNonVMI(t *testing.T) {
+	if _, err := VMIByReferencedPVCIndexFunc("not a vmi"); err == nil {
+		t.Fatal("expected an error for a non-VMI object")
+	}
+}
+
+func TestVMByReferencedPVCIndexFunc(t *testing.T) {
+	vm := &virtv1.VirtualMachine{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "vm1"},
+		Spec: virtv1.VirtualMachineSpec{
+			Template: &virtv1.VirtualMachineInstanceTemplateSpec{
+				Spec: virtv1.VirtualMachineInstanceSpec{
+					Volumes: []virtv1.Volume{
+						{
+							Name: "disk0",
+							VolumeSource: virtv1.VolumeSource{
+								PersistentVolumeClaim: &virtv1.PersistentVolumeClaimVolumeSource{
+									PersistentVolumeClaimVolumeSource: k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: "claim-a"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	keys, err := VMByReferencedPVCIndexFunc(vm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"default/claim-a"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+}
+
+func TestVMByReferencedPVCIndexFuncNoTemplate(t *testing.T) {
+	vm := &virtv1.VirtualMachine{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "vm1"}}
+	keys, err := VMByReferencedPVCIndexFunc(vm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("keys = %v, want none for a VM with no template", keys)
+	}
+}
+
+func TestVMByReferencedPVCIndexFuncRejectsNonVM(t *testing.T) {
+	if _, err := VMByReferencedPVCIndexFunc("not a vm"); err == nil {
+		t.Fatal("expected an error for a non-VM object")
+	}
+}
+
+func newVMIInformer(vmis ...*virtv1.VirtualMachineInstance) cache.SharedIndexInformer {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &virtv1.VirtualMachineInstance{}, 0, cache.Indexers{
+		VMIByReferencedPVCIndex: VMIByReferencedPVCIndexFunc,
+	})
+	for _, vmi := range vmis {
+		_ = informer.GetIndexer().Add(vmi)
+	}
+	return informer
+}
+
+func newVMInformer(vms ...*virtv1.VirtualMachine) cache.SharedIndexInformer {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &virtv1.VirtualMachine{}, 0, cache.Indexers{
+		VMByReferencedPVCIndex: VMByReferencedPVCIndexFunc,
+	})
+	for _, vm := range vms {
+		_ = informer.GetIndexer().Add(vm)
+	}
+	return informer
+}
+
+func vmWithPVC(namespace, name, claimName string) *virtv1.VirtualMachine {
+	return &virtv1.VirtualMachine{
+		ObjectMeta: v1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: virtv1.VirtualMachineSpec{
+			Template: &virtv1.VirtualMachineInstanceTemplateSpec{
+				Spec: virtv1.VirtualMachineInstanceSpec{
+					Volumes: []virtv1.Volume{{
+						Name: "disk0",
+						VolumeSource: virtv1.VolumeSource{
+							PersistentVolumeClaim: &virtv1.PersistentVolumeClaimVolumeSource{
+								PersistentVolumeClaimVolumeSource: k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func vmiWithPVC(namespace, name, claimName string) *virtv1.VirtualMachineInstance {
+	return &virtv1.VirtualMachineInstance{
+		ObjectMeta: v1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: virtv1.VirtualMachineInstanceSpec{
+			Volumes: []virtv1.Volume{{
+				Name: "disk0",
+				VolumeSource: virtv1.VolumeSource{
+					PersistentVolumeClaim: &virtv1.PersistentVolumeClaimVolumeSource{
+						PersistentVolumeClaimVolumeSource: k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+					},
+				},
+			}},
+		},
+	}
+}
+
+func TestHasVMIReference(t *testing.T) {
+	tests := []struct {
+		name          string
+		vmis          []*virtv1.VirtualMachineInstance
+		vms           []*virtv1.VirtualMachine
+		excludeVMIKey string
+		want          bool
+	}{
+		{
+			name: "no VMI or VM references the PVC",
+			want: false,
+		},
+		{
+			name: "a VMI references the PVC",
+			vmis: []*virtv1.VirtualMachineInstance{vmiWithPVC("default", "vmi1", "claim-a")},
+			want: true,
+		},
+		{
+			name:          "the only referencing VMI is excluded",
+			vmis:          []*virtv1.VirtualMachineInstance{vmiWithPVC("default", "vmi1", "claim-a")},
+			excludeVMIKey: "default/vmi1",
+			want:          false,
+		},
+		{
+			name: "a stopped VM with no VMI still references the PVC",
+			vms:  []*virtv1.VirtualMachine{vmWithPVC("default", "vm1", "claim-a")},
+			want: true,
+		},
+		{
+			name:          "the owning VM still references the PVC even though its VMI is excluded",
+			vmis:          []*virtv1.VirtualMachineInstance{vmiWithPVC("default", "vmi1", "claim-a")},
+			vms:           []*virtv1.VirtualMachine{vmWithPVC("default", "vm1", "claim-a")},
+			excludeVMIKey: "default/vmi1",
+			want:          true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmiInformer := newVMIInformer(tt.vmis...)
+			vmInformer := newVMInformer(tt.vms...)
+			got, err := HasVMIReference(vmiInformer, vmInformer, "default", "claim-a", tt.excludeVMIKey)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("HasVMIReference() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newMockClient(t *testing.T, objects ...runtime.Object) kubecli.KubevirtClient {
+	ctrl := gomock.NewController(t)
+	kubeClient := fake.NewSimpleClientset(objects...)
+	virtClient := kubecli.NewMockKubevirtClient(ctrl)
+	virtClient.EXPECT().CoreV1().Return(kubeClient.CoreV1()).AnyTimes()
+	return virtClient
+}
+
+func TestAddVMIProtectionFinalizer(t *testing.T) {
+	pvc := &k8sv1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a"}}
+	client := newMockClient(t, pvc)
+
+	if err := AddVMIProtectionFinalizer(client, pvc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, err := client.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), "claim-a", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !IsProtectedByVMI(updated) {
+		t.Fatal("expected the finalizer to be added")
+	}
+}
+
+func TestAddVMIProtectionFinalizerAlreadyPresent(t *testing.T) {
+	pvc := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a", Finalizers: []string{VMIProtectionFinalizer}},
+	}
+	client := newMockClient(t, pvc)
+
+	if err := AddVMIProtectionFinalizer(client, pvc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, err := client.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), "claim-a", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updated.Finalizers) != 1 {
+		t.Fatalf("finalizers = %v, want exactly one entry", updated.Finalizers)
+	}
+}
+
+func TestRemoveVMIProtectionFinalizer(t *testing.T) {
+	tests := []struct {
+		name             string
+		vmis             []*virtv1.VirtualMachineInstance
+		vms              []*virtv1.VirtualMachine
+		excludeVMIKey    string
+		wantStillPresent bool
+	}{
+		{
+			name:             "no remaining reference removes the finalizer",
+			wantStillPresent: false,
+		},
+		{
+			name:             "a surviving VMI keeps the finalizer",
+			vmis:             []*virtv1.VirtualMachineInstance{vmiWithPVC("default", "vmi2", "claim-a")},
+			wantStillPresent: true,
+		},
+		{
+			name:             "the deleting VMI is excluded from its own check",
+			vmis:             []*virtv1.VirtualMachineInstance{vmiWithPVC("default", "vmi1", "claim-a")},
+			excludeVMIKey:    "default/vmi1",
+			wantStillPresent: false,
+		},
+		{
+			name:             "a stopped VM still owning the PVC keeps the finalizer",
+			vmis:             []*virtv1.VirtualMachineInstance{vmiWithPVC("default", "vmi1", "claim-a")},
+			vms:              []*virtv1.VirtualMachine{vmWithPVC("default", "vm1", "claim-a")},
+			excludeVMIKey:    "default/vmi1",
+			wantStillPresent: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a", Finalizers: []string{VMIProtectionFinalizer}},
+			}
+			client := newMockClient(t, pvc)
+			vmiInformer := newVMIInformer(tt.vmis...)
+			vmInformer := newVMInformer(tt.vms...)
+
+			if err := RemoveVMIProtectionFinalizer(client, vmiInformer, vmInformer, pvc, tt.excludeVMIKey); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			updated, err := client.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), "claim-a", v1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := IsProtectedByVMI(updated); got != tt.wantStillPresent {
+				t.Fatalf("finalizer present = %v, want %v", got, tt.wantStillPresent)
+			}
+		})
+	}
+}
+
+func TestRemoveVMIProtectionFinalizerAlreadyAbsent(t *testing.T) {
+	pvc := &k8sv1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a"}}
+	client := newMockClient(t, pvc)
+	vmiInformer := newVMIInformer()
+	vmInformer := newVMInformer()
+
+	if err := RemoveVMIProtectionFinalizer(client, vmiInformer, vmInformer, pvc, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+// PVSourceKind identifies which kind of node-local volume source backs a PV.
+type PVSourceKind string
+
+const (
+	// PVSourceHostPath means the PV is backed by pv.Spec.HostPath.
+	PVSourceHostPath PVSourceKind = "HostPath"
+	// PVSourceLocal means the PV is backed by pv.Spec.Local.
+	PVSourceLocal PVSourceKind = "Local"
+	// PVSourceCSILocal means the PV is backed by a CSI driver that exposes a node-local
+	// path through its VolumeAttributes (e.g. the CSI hostpath driver).
+	PVSourceCSILocal PVSourceKind = "CSI"
+)
+
+// csiLocalPathKeys lists the VolumeAttributes keys that CSI hostpath-style drivers use
+// to publish the on-node backing path of a volume.
+var csiLocalPathKeys = []string{"path", "hostPath"}
+
+// PVBackingPath describes the node-local path backing a PV, and, when the PV is
+// constrained to a subset of nodes, the NodeAffinity term a scheduler must honor to land
+// on the node that actually has the path.
+type PVBackingPath struct {
+	Path         string
+	NodeSelector *k8sv1.NodeSelector
+	Source       PVSourceKind
+}
+
+// ResolvePVBackingPath inspects pv and returns its node-local backing path, recognizing
+// HostPath and Local volumes directly, and CSI volumes whose driver is present in
+// allowedCSIDrivers and whose VolumeAttributes carry a well-known path key. Returns nil
+// if pv is not backed by a node-local path KubeVirt knows how to resolve.
+func ResolvePVBackingPath(pv *k8sv1.PersistentVolume, allowedCSIDrivers []string) *PVBackingPath {
+	if pv == nil {
+		return nil
+	}
+	nodeSelector := pvNodeSelector(pv)
+
+	switch {
+	case pv.Spec.HostPath != nil:
+		return &PVBackingPath{Path: pv.Spec.HostPath.Path, NodeSelector: nodeSelector, Source: PVSourceHostPath}
+	case pv.Spec.Local != nil:
+		return &PVBackingPath{Path: pv.Spec.Local.Path, NodeSelector: nodeSelector, Source: PVSourceLocal}
+	case pv.Spec.CSI != nil && isAllowedCSIDriver(pv.Spec.CSI.Driver, allowedCSIDrivers):
+		for _, key := range csiLocalPathKeys {
+			if path, exists := pv.Spec.CSI.VolumeAttributes[key]; exists && path != "" {
+				return &PVBackingPath{Path: path, NodeSelector: nodeSelector, Source: PVSourceCSILocal}
+			}
+		}
+	}
+	return nil
+}
+
+func pvNodeSelector(pv *k8sv1.PersistentVolume) *k8sv1.NodeSelector {
+	if pv.Spec.NodeAffinity == nil {
+		return nil
+	}
+	return pv.Spec.NodeAffinity.Required
+}
+
+func isAllowedCSIDriver(driver string, allowedCSIDrivers []string) bool {
+	for _, allowed := range allowedCSIDrivers {
+		if driver == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"reflect"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+func TestResolvePVBackingPath(t *testing.T) {
+	nodeSelector := &k8sv1.NodeSelector{
+		NodeSelectorTerms: []k8sv1.NodeSelectorTerm{{
+			MatchExpressions: []k8sv1.NodeSelectorRequirement{{Key: "kubernetes.io/hostname", Operator: k8sv1.NodeSelectorOpIn, Values: []string{"node1"}}},
+		}},
+	}
+
+	tests := []struct {
+		name              string
+		pv                *k8sv1.PersistentVolume
+		allowedCSIDrivers []string
+		want              *PVBackingPath
+	}{
+		{
+			name: "nil PV resolves to nil",
+			pv:   nil,
+			want: nil,
+		},
+		{
+			name: "HostPath volume",
+			pv: &k8sv1.PersistentVolume{
+				Spec: k8sv1.PersistentVolumeSpec{
+					PersistentVolumeSource: k8sv1.PersistentVolumeSource{HostPath: &k8sv1.HostPathVolumeSource{Path: "/data"}},
+				},
+			},
+			want: &PVBackingPath{Path: "/data", Source: PVSourceHostPath},
+		},
+		{
+			name: "Local volume with a NodeAffinity selector",
+			pv: &k8sv1.PersistentVolume{
+				Spec: k8sv1.PersistentVolumeSpec{
+					PersistentVolumeSource: k8sv1.PersistentVolumeSource{Local: &k8sv1.LocalVolumeSource{Path: "/mnt/disks/ssd0"}},
+					NodeAffinity:           &k8sv1.VolumeNodeAffinity{Required: nodeSelector},
+				},
+			},
+			want: &PVBackingPath{Path: "/mnt/disks/ssd0", NodeSelector: nodeSelector, Source: PVSourceLocal},
+		},
+		{
+			name: "CSI volume from an allowed driver exposing a path attribute",
+			pv: &k8sv1.PersistentVolume{
+				Spec: k8sv1.PersistentVolumeSpec{
+					PersistentVolumeSource: k8sv1.PersistentVolumeSource{
+						CSI: &k8sv1.CSIPersistentVolumeSource{Driver: "hostpath.csi.k8s.io", VolumeAttributes: map[string]string{"path": "/csi-data"}},
+					},
+				},
+			},
+			allowedCSIDrivers: []string{"hostpath.csi.k8s.io"},
+			want:              &PVBackingPath{Path: "/csi-data", Source: PVSourceCSILocal},
+		},
+		{
+			name: "CSI volume from an allowed driver using the hostPath attribute key",
+			pv: &k8sv1.PersistentVolume{
+				Spec: k8sv1.PersistentVolumeSpec{
+					PersistentVolumeSource: k8sv1.PersistentVolumeSource{
+						CSI: &k8sv1.CSIPersistentVolumeSource{Driver: "hostpath.csi.k8s.io", VolumeAttributes: map[string]string{"hostPath": "/csi-data"}},
+					},
+				},
+			},
+			allowedCSIDrivers: []string{"hostpath.csi.k8s.io"},
+			want:              &PVBackingPath{Path: "/csi-data", Source: PVSourceCSILocal},
+		},
+		{
+			name: "CSI volume from a driver not in the allow-list",
+			pv: &k8sv1.PersistentVolume{
+				Spec: k8sv1.PersistentVolumeSpec{
+					PersistentVolumeSource: k8sv1.PersistentVolumeSource{
+						CSI: &k8sv1.CSIPersistentVolumeSource{Driver: "other.csi.k8s.io", VolumeAttributes: map[string]string{"path": "/csi-data"}},
+					},
+				},
+			},
+			allowedCSIDrivers: []string{"hostpath.csi.k8s.io"},
+			want:              nil,
+		},
+		{
+			name: "CSI volume from an allowed driver with no recognized path attribute",
+			pv: &k8sv1.PersistentVolume{
+				Spec: k8sv1.PersistentVolumeSpec{
+					PersistentVolumeSource: k8sv1.PersistentVolumeSource{
+						CSI: &k8sv1.CSIPersistentVolumeSource{Driver: "hostpath.csi.k8s.io", VolumeAttributes: map[string]string{"unrelated": "value"}},
+					},
+				},
+			},
+			allowedCSIDrivers: []string{"hostpath.csi.k8s.io"},
+			want:              nil,
+		},
+		{
+			name: "non-node-local volume source",
+			pv: &k8sv1.PersistentVolume{
+				Spec: k8sv1.PersistentVolumeSpec{
+					PersistentVolumeSource: k8sv1.PersistentVolumeSource{NFS: &k8sv1.NFSVolumeSource{Server: "nfs.example.com", Path: "/export"}},
+				},
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ResolvePVBackingPath(tt.pv, tt.allowedCSIDrivers)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ResolvePVBackingPath() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -24,32 +24,51 @@ import (
 	"fmt"
 
 	k8sv1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/tools/cache"
-
-	"kubevirt.io/client-go/kubecli"
 )
 
-func IsPVCBlockFromStore(store cache.Store, namespace string, claimName string) (pvc *k8sv1.PersistentVolumeClaim, exists bool, isBlockDevice bool, err error) {
-	obj, exists, err := store.GetByKey(namespace + "/" + claimName)
+// IsPVCBlockFromStore determines whether claimName is a block-mode PVC, via resolver's
+// indexed informer caches. It delegates to VolumeResolver so that this and the other
+// single-shot helpers below share one PVC/PV lookup and classification path with the
+// batch API instead of each re-implementing it.
+func IsPVCBlockFromStore(ctx context.Context, resolver *VolumeResolver, namespace string, claimName string) (pvc *k8sv1.PersistentVolumeClaim, exists bool, isBlockDevice bool, err error) {
+	rv, found, err := resolver.ResolveOne(ctx, namespace, claimName)
+	if err != nil || !found {
+		return nil, found, false, err
+	}
+	return rv.PVC, true, rv.IsBlock, nil
+}
+
+// IsPVCBlockFromStoreForPod resolves vol against pod (preferring the pod-owned ephemeral
+// claim name when vol.Ephemeral is set) and behaves like IsPVCBlockFromStore, additionally
+// verifying that an ephemeral volume's auto-generated PVC is actually owned by pod.
+func IsPVCBlockFromStoreForPod(ctx context.Context, resolver *VolumeResolver, pod *k8sv1.Pod, vol k8sv1.Volume) (pvc *k8sv1.PersistentVolumeClaim, exists bool, isBlockDevice bool, err error) {
+	claimName := EphemeralVolumeClaimName(pod, vol)
+	if claimName == "" {
+		return nil, false, false, fmt.Errorf("volume %s is not backed by a PVC", vol.Name)
+	}
+	pvc, exists, isBlockDevice, err = IsPVCBlockFromStore(ctx, resolver, pod.Namespace, claimName)
 	if err != nil || !exists {
-		return nil, exists, false, err
+		return pvc, exists, isBlockDevice, err
 	}
-	if pvc, ok := obj.(*k8sv1.PersistentVolumeClaim); ok {
-		return obj.(*k8sv1.PersistentVolumeClaim), true, isPVCBlock(pvc), nil
+	if vol.Ephemeral != nil && !isPVCOwnedByPod(pvc, pod) {
+		return nil, false, false, ErrPVCNotOwnedByPod
 	}
-	return nil, false, false, fmt.Errorf("this is not a PVC! %v", obj)
+	return pvc, exists, isBlockDevice, nil
 }
 
-func IsPVCBlockFromClient(client kubecli.KubevirtClient, namespace string, claimName string) (pvc *k8sv1.PersistentVolumeClaim, exists bool, isBlockDevice bool, err error) {
-	pvc, err = client.CoreV1().PersistentVolumeClaims(namespace).Get(context.Background(), claimName, v1.GetOptions{})
-	if errors.IsNotFound(err) {
-		return nil, false, false, nil
-	} else if err != nil {
-		return nil, false, false, err
-	}
-	return pvc, true, isPVCBlock(pvc), nil
+// IsPVCBlockFromClient behaves like IsPVCBlockFromStore, but is for callers (e.g.
+// one-shot admission/validation paths) that only have a client, not a synced informer.
+// It is identical to IsPVCBlockFromStore: resolver.ResolveOne falls back to a live Get
+// whenever its cache misses, so a resolver over unsynced informers answers from the client.
+func IsPVCBlockFromClient(ctx context.Context, resolver *VolumeResolver, namespace string, claimName string) (pvc *k8sv1.PersistentVolumeClaim, exists bool, isBlockDevice bool, err error) {
+	return IsPVCBlockFromStore(ctx, resolver, namespace, claimName)
+}
+
+// IsPVCBlockFromClientForPod resolves vol against pod (preferring the pod-owned ephemeral
+// claim name when vol.Ephemeral is set) and behaves like IsPVCBlockFromClient, additionally
+// verifying that an ephemeral volume's auto-generated PVC is actually owned by pod.
+func IsPVCBlockFromClientForPod(ctx context.Context, resolver *VolumeResolver, pod *k8sv1.Pod, vol k8sv1.Volume) (pvc *k8sv1.PersistentVolumeClaim, exists bool, isBlockDevice bool, err error) {
+	return IsPVCBlockFromStoreForPod(ctx, resolver, pod, vol)
 }
 
 func isPVCBlock(pvc *k8sv1.PersistentVolumeClaim) bool {
@@ -69,44 +88,100 @@ func IsPVCShared(pvc *k8sv1.PersistentVolumeClaim) bool {
 	return false
 }
 
-func IsSharedPVCFromClient(client kubecli.KubevirtClient, namespace string, claimName string) (pvc *k8sv1.PersistentVolumeClaim, isShared bool, err error) {
-	pvc, err = client.CoreV1().PersistentVolumeClaims(namespace).Get(context.Background(), claimName, v1.GetOptions{})
-	if err == nil {
-		isShared = IsPVCShared(pvc)
+// IsSharedPVCFromClient behaves like IsPVCBlockFromClient but reports whether claimName
+// is a shared (ReadWriteMany) PVC instead of its VolumeMode.
+func IsSharedPVCFromClient(ctx context.Context, resolver *VolumeResolver, namespace string, claimName string) (pvc *k8sv1.PersistentVolumeClaim, isShared bool, err error) {
+	rv, found, err := resolver.ResolveOne(ctx, namespace, claimName)
+	if err != nil || !found {
+		return nil, false, err
 	}
-	return
+	return rv.PVC, rv.IsShared, nil
 }
 
-// GetPVCHostPathFromStore determines if the persistent volume bound to the passed in claim is a host path based
-// volume, and if so, returns the path of the volume. Returns a blank path if not a hostpath volume
-func GetPVCHostPathFromStore(pvcStore cache.Store, pvStore cache.Store, namespace string, claimName string) (string, error) {
-	var pvc *k8sv1.PersistentVolumeClaim
-	obj, exists, err := pvcStore.GetByKey(namespace + "/" + claimName)
-	if err != nil {
-		return "", err
+// IsSharedPVCFromClientForPod resolves vol against pod (preferring the pod-owned ephemeral
+// claim name when vol.Ephemeral is set) and behaves like IsSharedPVCFromClient, additionally
+// verifying that an ephemeral volume's auto-generated PVC is actually owned by pod.
+func IsSharedPVCFromClientForPod(ctx context.Context, resolver *VolumeResolver, pod *k8sv1.Pod, vol k8sv1.Volume) (pvc *k8sv1.PersistentVolumeClaim, isShared bool, err error) {
+	claimName := EphemeralVolumeClaimName(pod, vol)
+	if claimName == "" {
+		return nil, false, fmt.Errorf("volume %s is not backed by a PVC", vol.Name)
+	}
+	pvc, isShared, err = IsSharedPVCFromClient(ctx, resolver, pod.Namespace, claimName)
+	if err != nil || pvc == nil {
+		return pvc, false, err
 	}
-	if !exists {
-		return "", fmt.Errorf("Unable to find PVC %s/%s", namespace, claimName)
+	if vol.Ephemeral != nil && !isPVCOwnedByPod(pvc, pod) {
+		return nil, false, ErrPVCNotOwnedByPod
+	}
+	return pvc, isShared, nil
+}
+
+// GetPVCHostPathFromStore determines if the persistent volume bound to claimName is a
+// node-local volume (HostPath, Local, or a CSI driver in resolver's allow-list exposing a
+// path), and if so, returns its backing path, node selector, and source kind. Returns nil
+// if not a node-local volume. It delegates to VolumeResolver like the other helpers in
+// this file, so the allow-list and PV lookup live in one place.
+func GetPVCHostPathFromStore(ctx context.Context, resolver *VolumeResolver, namespace string, claimName string) (*PVBackingPath, error) {
+	rv, found, err := resolver.ResolveOne(ctx, namespace, claimName)
+	if err != nil {
+		return nil, err
 	}
-	if _, ok := obj.(*k8sv1.PersistentVolumeClaim); ok {
-		pvc = obj.(*k8sv1.PersistentVolumeClaim)
+	if !found {
+		return nil, fmt.Errorf("unable to find PVC %s/%s", namespace, claimName)
 	}
+	return rv.BackingPath, nil
+}
 
-	if pvc.Status.Phase == k8sv1.ClaimBound && pvc.Spec.VolumeName != "" {
-		var pv *k8sv1.PersistentVolume
-		obj, exists, err := pvStore.GetByKey(pvc.Spec.VolumeName)
+// GetPVCHostPathFromStoreForPod resolves vol against pod (preferring the pod-owned ephemeral
+// claim name when vol.Ephemeral is set) and behaves like GetPVCHostPathFromStore, additionally
+// verifying that an ephemeral volume's auto-generated PVC is actually owned by pod.
+func GetPVCHostPathFromStoreForPod(ctx context.Context, resolver *VolumeResolver, pod *k8sv1.Pod, vol k8sv1.Volume) (*PVBackingPath, error) {
+	claimName := EphemeralVolumeClaimName(pod, vol)
+	if claimName == "" {
+		return nil, fmt.Errorf("volume %s is not backed by a PVC", vol.Name)
+	}
+	if vol.Ephemeral != nil {
+		rv, found, err := resolver.ResolveOne(ctx, pod.Namespace, claimName)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		if !exists {
-			return "", fmt.Errorf("Unable to find PV %s", pvc.Spec.VolumeName)
+		if !found {
+			return nil, fmt.Errorf("unable to find PVC %s/%s", pod.Namespace, claimName)
 		}
-		if _, ok := obj.(*k8sv1.PersistentVolume); ok {
-			pv = obj.(*k8sv1.PersistentVolume)
+		if !isPVCOwnedByPod(rv.PVC, pod) {
+			return nil, ErrPVCNotOwnedByPod
 		}
-		if pv.Spec.HostPath != nil {
-			return pv.Spec.HostPath.Path, nil
+	}
+	return GetPVCHostPathFromStore(ctx, resolver, pod.Namespace, claimName)
+}
+
+// ErrPVCNotOwnedByPod is returned when a pod's ephemeral volume resolves to a PVC name
+// that already exists but was not created for this pod, e.g. because a pre-existing PVC
+// of the same name was created out-of-band. Callers should treat this as a hard failure
+// rather than silently attaching the pre-existing PVC.
+var ErrPVCNotOwnedByPod = fmt.Errorf("PVC exists but is not owned by the referencing pod")
+
+// EphemeralVolumeClaimName returns the name of the PVC backing vol. Pod-owned ephemeral
+// volumes (vol.Ephemeral) resolve to their auto-generated claim name, "<pod-name>-<volume-name>",
+// matching the name the kubelet's ephemeral volume controller creates. Regular
+// PersistentVolumeClaim volumes resolve to the claim name declared on the volume. Returns
+// an empty string if vol is not backed by a PVC at all.
+func EphemeralVolumeClaimName(pod *k8sv1.Pod, vol k8sv1.Volume) string {
+	if vol.Ephemeral != nil {
+		return pod.Name + "-" + vol.Name
+	}
+	if vol.PersistentVolumeClaim != nil {
+		return vol.PersistentVolumeClaim.ClaimName
+	}
+	return ""
+}
+
+// isPVCOwnedByPod reports whether pvc's OwnerReferences include pod, matching by UID.
+func isPVCOwnedByPod(pvc *k8sv1.PersistentVolumeClaim, pod *k8sv1.Pod) bool {
+	for _, ref := range pvc.OwnerReferences {
+		if ref.UID == pod.UID {
+			return true
 		}
 	}
-	return "", nil
+	return false
 }
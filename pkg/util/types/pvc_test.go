@@ -0,0 +1,218 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+)
+
+func TestEphemeralVolumeClaimName(t *testing.T) {
+	pod := &k8sv1.Pod{ObjectMeta: v1.ObjectMeta{Name: "pod1"}}
+
+	tests := []struct {
+		name string
+		vol  k8sv1.Volume
+		want string
+	}{
+		{
+			name: "ephemeral volume resolves to the kubelet-generated claim name",
+			vol:  k8sv1.Volume{Name: "disk0", VolumeSource: k8sv1.VolumeSource{Ephemeral: &k8sv1.EphemeralVolumeSource{}}},
+			want: "pod1-disk0",
+		},
+		{
+			name: "plain PersistentVolumeClaim volume resolves to its declared claim name",
+			vol: k8sv1.Volume{
+				Name: "disk0",
+				VolumeSource: k8sv1.VolumeSource{
+					PersistentVolumeClaim: &k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: "claim-a"},
+				},
+			},
+			want: "claim-a",
+		},
+		{
+			name: "volume not backed by a PVC resolves to empty",
+			vol:  k8sv1.Volume{Name: "disk0", VolumeSource: k8sv1.VolumeSource{EmptyDir: &k8sv1.EmptyDirVolumeSource{}}},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EphemeralVolumeClaimName(pod, tt.vol); got != tt.want {
+				t.Fatalf("EphemeralVolumeClaimName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPVCOwnedByPod(t *testing.T) {
+	pod := &k8sv1.Pod{ObjectMeta: v1.ObjectMeta{Name: "pod1", UID: k8stypes.UID("pod-uid")}}
+
+	tests := []struct {
+		name string
+		pvc  *k8sv1.PersistentVolumeClaim
+		want bool
+	}{
+		{
+			name: "owned by the pod",
+			pvc: &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{OwnerReferences: []v1.OwnerReference{{UID: pod.UID}}},
+			},
+			want: true,
+		},
+		{
+			name: "owned by a different UID",
+			pvc: &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{OwnerReferences: []v1.OwnerReference{{UID: k8stypes.UID("other-uid")}}},
+			},
+			want: false,
+		},
+		{
+			name: "no owner references at all",
+			pvc:  &k8sv1.PersistentVolumeClaim{},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPVCOwnedByPod(tt.pvc, pod); got != tt.want {
+				t.Fatalf("isPVCOwnedByPod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestVolumeResolver builds a VolumeResolver over freshly-indexed, manually-populated
+// informers, pre-seeded with pvc (if non-nil), so the ForPod helpers below can be
+// exercised without a live apiserver.
+func newTestVolumeResolver(t *testing.T, pvc *k8sv1.PersistentVolumeClaim) *VolumeResolver {
+	pvcInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &k8sv1.PersistentVolumeClaim{}, 0, cache.Indexers{
+		PVCByNamespaceClaimNameIndex: PVCByNamespaceClaimNameIndexFunc,
+	})
+	if pvc != nil {
+		if err := pvcInformer.GetIndexer().Add(pvc); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	pvInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &k8sv1.PersistentVolume{}, 0, cache.Indexers{
+		PVByClaimRefIndex: PVByClaimRefIndexFunc,
+	})
+	dvInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &cdiv1.DataVolume{}, 0, cache.Indexers{
+		DataVolumeByNamespaceNameIndex: DataVolumeByNamespaceNameIndexFunc,
+	})
+	csiDriverInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &storagev1.CSIDriver{}, 0, cache.Indexers{})
+
+	client := newMockClient(t)
+	resolver, err := NewVolumeResolver(client, pvcInformer, pvInformer, dvInformer, csiDriverInformer, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return resolver
+}
+
+func TestIsPVCBlockFromStoreForPodRejectsUnownedEphemeralPVC(t *testing.T) {
+	pod := &k8sv1.Pod{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "pod1", UID: k8stypes.UID("pod-uid")}}
+	vol := k8sv1.Volume{Name: "disk0", VolumeSource: k8sv1.VolumeSource{Ephemeral: &k8sv1.EphemeralVolumeSource{}}}
+	pvc := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "pod1-disk0"},
+	}
+	resolver := newTestVolumeResolver(t, pvc)
+
+	_, _, _, err := IsPVCBlockFromStoreForPod(context.Background(), resolver, pod, vol)
+	if !errors.Is(err, ErrPVCNotOwnedByPod) {
+		t.Fatalf("err = %v, want ErrPVCNotOwnedByPod", err)
+	}
+}
+
+func TestIsPVCBlockFromClientForPodRejectsUnownedEphemeralPVC(t *testing.T) {
+	pod := &k8sv1.Pod{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "pod1", UID: k8stypes.UID("pod-uid")}}
+	vol := k8sv1.Volume{Name: "disk0", VolumeSource: k8sv1.VolumeSource{Ephemeral: &k8sv1.EphemeralVolumeSource{}}}
+	pvc := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "pod1-disk0"},
+	}
+	resolver := newTestVolumeResolver(t, pvc)
+
+	_, _, _, err := IsPVCBlockFromClientForPod(context.Background(), resolver, pod, vol)
+	if !errors.Is(err, ErrPVCNotOwnedByPod) {
+		t.Fatalf("err = %v, want ErrPVCNotOwnedByPod", err)
+	}
+}
+
+func TestIsSharedPVCFromClientForPodRejectsUnownedEphemeralPVC(t *testing.T) {
+	pod := &k8sv1.Pod{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "pod1", UID: k8stypes.UID("pod-uid")}}
+	vol := k8sv1.Volume{Name: "disk0", VolumeSource: k8sv1.VolumeSource{Ephemeral: &k8sv1.EphemeralVolumeSource{}}}
+	pvc := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "pod1-disk0"},
+	}
+	resolver := newTestVolumeResolver(t, pvc)
+
+	_, _, err := IsSharedPVCFromClientForPod(context.Background(), resolver, pod, vol)
+	if !errors.Is(err, ErrPVCNotOwnedByPod) {
+		t.Fatalf("err = %v, want ErrPVCNotOwnedByPod", err)
+	}
+}
+
+func TestGetPVCHostPathFromStoreForPodRejectsUnownedEphemeralPVC(t *testing.T) {
+	pod := &k8sv1.Pod{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "pod1", UID: k8stypes.UID("pod-uid")}}
+	vol := k8sv1.Volume{Name: "disk0", VolumeSource: k8sv1.VolumeSource{Ephemeral: &k8sv1.EphemeralVolumeSource{}}}
+	pvc := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "pod1-disk0"},
+	}
+	resolver := newTestVolumeResolver(t, pvc)
+
+	_, err := GetPVCHostPathFromStoreForPod(context.Background(), resolver, pod, vol)
+	if !errors.Is(err, ErrPVCNotOwnedByPod) {
+		t.Fatalf("err = %v, want ErrPVCNotOwnedByPod", err)
+	}
+}
+
+func TestIsPVCBlockFromStoreForPodAllowsOwnedEphemeralPVC(t *testing.T) {
+	pod := &k8sv1.Pod{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "pod1", UID: k8stypes.UID("pod-uid")}}
+	vol := k8sv1.Volume{Name: "disk0", VolumeSource: k8sv1.VolumeSource{Ephemeral: &k8sv1.EphemeralVolumeSource{}}}
+	blockMode := k8sv1.PersistentVolumeBlock
+	pvc := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "pod1-disk0",
+			OwnerReferences: []v1.OwnerReference{{UID: pod.UID}},
+		},
+		Spec: k8sv1.PersistentVolumeClaimSpec{VolumeMode: &blockMode},
+	}
+	resolver := newTestVolumeResolver(t, pvc)
+
+	got, exists, isBlock, err := IsPVCBlockFromStoreForPod(context.Background(), resolver, pod, vol)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists || !isBlock || got != pvc {
+		t.Fatalf("exists = %v, isBlock = %v, pvc = %v, want true, true, %v", exists, isBlock, got, pvc)
+	}
+}
@@ -0,0 +1,405 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	k8sv1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	cdiv1 "kubevirt.io/containerized-data-importer-api/pkg/apis/core/v1beta1"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+const (
+	// PVCByNamespaceClaimNameIndex, PVByClaimRefIndex, and DataVolumeByNamespaceNameIndex
+	// are the indexer names NewVolumeResolver requires its informers to already have
+	// registered, using PVCByNamespaceClaimNameIndexFunc, PVByClaimRefIndexFunc, and
+	// DataVolumeByNamespaceNameIndexFunc respectively. Register them at informer-factory
+	// construction time: SharedIndexInformer.AddIndexers rejects new indexers once the
+	// informer has started, which is before any consumer gets a chance to call
+	// NewVolumeResolver.
+	PVCByNamespaceClaimNameIndex   = "by-namespace-claimName"
+	PVByClaimRefIndex              = "by-claimRef"
+	DataVolumeByNamespaceNameIndex = "by-namespace-name"
+
+	// maxConcurrentLiveLookups bounds how many live client Gets VolumeResolver issues
+	// in parallel for refs that miss every informer cache, so a reconcile of a VMI with
+	// many disks can't fan out into an unbounded burst of apiserver calls.
+	maxConcurrentLiveLookups = 8
+)
+
+// VolumeRef identifies a single PVC to resolve within the namespace passed to Resolve.
+type VolumeRef struct {
+	ClaimName string
+}
+
+// ResolvedVolume is everything virt-controller typically needs to know about a VMI disk
+// backed by a PVC, gathered in one pass instead of the several independent lookups the
+// single-shot helpers require.
+type ResolvedVolume struct {
+	ClaimName           string
+	PVC                 *k8sv1.PersistentVolumeClaim
+	PV                  *k8sv1.PersistentVolume
+	IsBlock             bool
+	IsShared            bool
+	BackingPath         *PVBackingPath
+	EffectiveAccessMode k8sv1.PersistentVolumeAccessMode
+	CSIDriverName       string
+	// Migratable mirrors ResolveEffectiveAccessMode's migratable return: true if
+	// EffectiveAccessMode is ReadWriteMany and, for a CSI-backed PV, its driver is
+	// actually installed per the indexed CSIDriver cache (RWX itself is the multi-node
+	// attach signal; Kubernetes will not bind a PVC RWX unless the volume supports it).
+	Migratable bool
+}
+
+// VolumeResolver batches PVC/PV/DataVolume/CSIDriver lookups for a set of volumes behind
+// indexed informer caches, so resolving every disk of a VMI costs one pass over local
+// indexes instead of one store lookup (and potential live Get fallback) per disk.
+type VolumeResolver struct {
+	client kubecli.KubevirtClient
+
+	pvcInformer       cache.SharedIndexInformer
+	pvInformer        cache.SharedIndexInformer
+	dvInformer        cache.SharedIndexInformer
+	csiDriverInformer cache.SharedIndexInformer
+
+	allowedCSIDrivers []string
+}
+
+// NewVolumeResolver builds a VolumeResolver on top of informers that must already have
+// PVCByNamespaceClaimNameIndex/PVByClaimRefIndex/DataVolumeByNamespaceNameIndex
+// registered (e.g. by the shared informer factory that constructs them), since
+// SharedIndexInformer.AddIndexers errors out once its informer has started and
+// NewVolumeResolver is typically called well after that point. It only verifies the
+// indexers are present; it never registers them itself.
+func NewVolumeResolver(
+	client kubecli.KubevirtClient,
+	pvcInformer cache.SharedIndexInformer,
+	pvInformer cache.SharedIndexInformer,
+	dvInformer cache.SharedIndexInformer,
+	csiDriverInformer cache.SharedIndexInformer,
+	allowedCSIDrivers []string,
+) (*VolumeResolver, error) {
+	if err := verifyIndexer(pvcInformer, PVCByNamespaceClaimNameIndex); err != nil {
+		return nil, err
+	}
+	if err := verifyIndexer(pvInformer, PVByClaimRefIndex); err != nil {
+		return nil, err
+	}
+	if err := verifyIndexer(dvInformer, DataVolumeByNamespaceNameIndex); err != nil {
+		return nil, err
+	}
+
+	return &VolumeResolver{
+		client:            client,
+		pvcInformer:       pvcInformer,
+		pvInformer:        pvInformer,
+		dvInformer:        dvInformer,
+		csiDriverInformer: csiDriverInformer,
+		allowedCSIDrivers: allowedCSIDrivers,
+	}, nil
+}
+
+func verifyIndexer(informer cache.SharedIndexInformer, name string) error {
+	if _, ok := informer.GetIndexer().GetIndexers()[name]; ok {
+		return nil
+	}
+	return fmt.Errorf("informer is missing required indexer %q; it must be registered before the informer is started", name)
+}
+
+// PVCByNamespaceClaimNameIndexFunc indexes PVCs under PVCByNamespaceClaimNameIndex.
+func PVCByNamespaceClaimNameIndexFunc(obj interface{}) ([]string, error) {
+	pvc, ok := obj.(*k8sv1.PersistentVolumeClaim)
+	if !ok {
+		return nil, fmt.Errorf("object is not a PVC: %v", obj)
+	}
+	return []string{pvc.Namespace + "/" + pvc.Name}, nil
+}
+
+// PVByClaimRefIndexFunc indexes PVs under PVByClaimRefIndex, keyed by the namespace/name
+// of the PVC each PV is (or was) bound to.
+func PVByClaimRefIndexFunc(obj interface{}) ([]string, error) {
+	pv, ok := obj.(*k8sv1.PersistentVolume)
+	if !ok {
+		return nil, fmt.Errorf("object is not a PV: %v", obj)
+	}
+	if pv.Spec.ClaimRef == nil {
+		return nil, nil
+	}
+	return []string{pv.Spec.ClaimRef.Namespace + "/" + pv.Spec.ClaimRef.Name}, nil
+}
+
+// DataVolumeByNamespaceNameIndexFunc indexes DataVolumes under DataVolumeByNamespaceNameIndex.
+func DataVolumeByNamespaceNameIndexFunc(obj interface{}) ([]string, error) {
+	dv, ok := obj.(*cdiv1.DataVolume)
+	if !ok {
+		return nil, fmt.Errorf("object is not a DataVolume: %v", obj)
+	}
+	return []string{dv.Namespace + "/" + dv.Name}, nil
+}
+
+// Resolve looks up every ref in refs against namespace in a single pass, resolving each
+// distinct ClaimName once and reusing the result for any repeats, and falls back to a
+// bounded-parallel live client Get for any PVC missing from the informer cache (e.g. not
+// yet synced). The returned slice always has len(refs) entries in the same order as
+// refs, so callers can zip the input and output by index even when refs contains
+// repeated ClaimNames (e.g. two disks backed by the same PVC).
+func (r *VolumeResolver) Resolve(ctx context.Context, namespace string, refs []VolumeRef) ([]ResolvedVolume, error) {
+	order, firstIndex := dedupeClaimNames(refs)
+
+	unique := make([]ResolvedVolume, len(order))
+	var missing []int
+	for i, claimName := range order {
+		pvc, found, err := r.pvcFromStore(namespace, claimName)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			missing = append(missing, i)
+			continue
+		}
+		rv, err := r.resolveForPVC(claimName, pvc)
+		if err != nil {
+			return nil, err
+		}
+		unique[i] = rv
+	}
+
+	if err := r.resolveMissingLive(ctx, namespace, order, missing, unique); err != nil {
+		return nil, err
+	}
+
+	resolved := make([]ResolvedVolume, len(refs))
+	for i, ref := range refs {
+		resolved[i] = unique[firstIndex[ref.ClaimName]]
+	}
+	return resolved, nil
+}
+
+// dedupeClaimNames returns the distinct ClaimNames in refs in first-seen order, plus a
+// map back from each ClaimName to its position in that order, so a caller can resolve
+// each distinct claim once and then reconstruct a len(refs)-long, input-order result by
+// indexing through firstIndex.
+func dedupeClaimNames(refs []VolumeRef) (order []string, firstIndex map[string]int) {
+	firstIndex = make(map[string]int, len(refs))
+	order = make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if _, ok := firstIndex[ref.ClaimName]; ok {
+			continue
+		}
+		firstIndex[ref.ClaimName] = len(order)
+		order = append(order, ref.ClaimName)
+	}
+	return order, firstIndex
+}
+
+// ResolveOne is the single-claim counterpart to Resolve, used by the legacy single-shot
+// PVC helpers in pvc.go so they share this type's store-then-live-fallback lookup and
+// PV/CSI resolution instead of duplicating it. found reports whether claimName resolved
+// to a PVC at all (a missing PVC is not an error here, unlike Resolve, which treats a
+// claim that never materializes and has no backing DataVolume as a hard failure).
+func (r *VolumeResolver) ResolveOne(ctx context.Context, namespace, claimName string) (rv ResolvedVolume, found bool, err error) {
+	pvc, found, err := r.pvcFromStore(namespace, claimName)
+	if err != nil {
+		return ResolvedVolume{}, false, err
+	}
+	if !found {
+		pvc, err = r.client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, claimName, v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return ResolvedVolume{}, false, nil
+		}
+		if err != nil {
+			return ResolvedVolume{}, false, err
+		}
+	}
+	rv, err = r.resolveForPVC(claimName, pvc)
+	if err != nil {
+		return ResolvedVolume{}, false, err
+	}
+	return rv, true, nil
+}
+
+func (r *VolumeResolver) pvcFromStore(namespace, claimName string) (*k8sv1.PersistentVolumeClaim, bool, error) {
+	objs, err := r.pvcInformer.GetIndexer().ByIndex(PVCByNamespaceClaimNameIndex, namespace+"/"+claimName)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(objs) == 0 {
+		return nil, false, nil
+	}
+	pvc, ok := objs[0].(*k8sv1.PersistentVolumeClaim)
+	if !ok {
+		return nil, false, fmt.Errorf("this is not a PVC! %v", objs[0])
+	}
+	return pvc, true, nil
+}
+
+func (r *VolumeResolver) resolveMissingLive(ctx context.Context, namespace string, order []string, missing []int, resolved []ResolvedVolume) error {
+	if len(missing) == 0 {
+		return nil
+	}
+
+	sem := make(chan struct{}, maxConcurrentLiveLookups)
+	var wg sync.WaitGroup
+	errs := make([]error, len(missing))
+
+	for n, i := range missing {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n, i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			claimName := order[i]
+			pvc, err := r.client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, claimName, v1.GetOptions{})
+			if errors.IsNotFound(err) {
+				// The DataVolume importer/cloner may not have materialized the PVC
+				// yet. That's a pending state, not a resolution error, as long as
+				// the DataVolume it will come from actually exists.
+				if r.dataVolumeExists(namespace, claimName) {
+					return
+				}
+				errs[n] = fmt.Errorf("PVC %s/%s not found and no matching DataVolume exists", namespace, claimName)
+				return
+			}
+			if err != nil {
+				errs[n] = err
+				return
+			}
+			rv, err := r.resolveForPVC(claimName, pvc)
+			if err != nil {
+				errs[n] = err
+				return
+			}
+			resolved[i] = rv
+		}(n, i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *VolumeResolver) resolveForPVC(claimName string, pvc *k8sv1.PersistentVolumeClaim) (ResolvedVolume, error) {
+	rv := ResolvedVolume{
+		ClaimName: claimName,
+		PVC:       pvc,
+		IsBlock:   isPVCBlock(pvc),
+		IsShared:  IsPVCShared(pvc),
+	}
+
+	// A PVC can have Spec.VolumeName set (and so have a PV worth resolving) before its
+	// Status.Phase catches up to Bound, so gate on VolumeName alone, matching
+	// IsPVCBlockResolved.
+	if pvc.Spec.VolumeName == "" {
+		return rv, nil
+	}
+
+	pv, found, err := r.pvFromStore(pvc.Namespace, pvc.Name, pvc.Spec.VolumeName)
+	if err != nil {
+		return ResolvedVolume{}, err
+	}
+	if !found {
+		return rv, nil
+	}
+	rv.PV = pv
+	rv.BackingPath = ResolvePVBackingPath(pv, r.allowedCSIDrivers)
+	rv.EffectiveAccessMode = intersectAccessMode(pvc.Spec.AccessModes, pv.Spec.AccessModes)
+	if pv.Spec.CSI != nil {
+		rv.CSIDriverName = pv.Spec.CSI.Driver
+	}
+	rv.Migratable = r.resolveMigratable(pv, rv.EffectiveAccessMode)
+	// A PVC that leaves VolumeMode unset still binds to whatever mode its PV declares;
+	// isPVCBlock alone would wrongly report Filesystem in that case.
+	if pvc.Spec.VolumeMode == nil && pv.Spec.VolumeMode != nil {
+		rv.IsBlock = *pv.Spec.VolumeMode == k8sv1.PersistentVolumeBlock
+	}
+	return rv, nil
+}
+
+func (r *VolumeResolver) pvFromStore(namespace, claimName, pvName string) (*k8sv1.PersistentVolume, bool, error) {
+	objs, err := r.pvInformer.GetIndexer().ByIndex(PVByClaimRefIndex, namespace+"/"+claimName)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, obj := range objs {
+		if pv, ok := obj.(*k8sv1.PersistentVolume); ok && pv.Name == pvName {
+			return pv, true, nil
+		}
+	}
+	// Fall back to a direct by-name lookup: the claimRef index only helps once the PV
+	// has bound back to the claim, which can lag the PVC's own bound status briefly.
+	obj, exists, err := r.pvInformer.GetStore().GetByKey(pvName)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+	pv, ok := obj.(*k8sv1.PersistentVolume)
+	if !ok {
+		return nil, false, fmt.Errorf("this is not a PV! %v", obj)
+	}
+	return pv, true, nil
+}
+
+func (r *VolumeResolver) dataVolumeExists(namespace, name string) bool {
+	objs, err := r.dvInformer.GetIndexer().ByIndex(DataVolumeByNamespaceNameIndex, namespace+"/"+name)
+	return err == nil && len(objs) > 0
+}
+
+// csiDriverFromStore looks up a CSIDriver by name from the indexed informer cache,
+// mirroring the live lookup ResolveEffectiveAccessMode does for the single-shot path.
+func (r *VolumeResolver) csiDriverFromStore(name string) (*storagev1.CSIDriver, bool, error) {
+	obj, exists, err := r.csiDriverInformer.GetStore().GetByKey(name)
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+	driver, ok := obj.(*storagev1.CSIDriver)
+	if !ok {
+		return nil, false, fmt.Errorf("this is not a CSIDriver! %v", obj)
+	}
+	return driver, true, nil
+}
+
+// resolveMigratable reports whether a VMI using pv can safely be live-migrated, the same
+// way ResolveEffectiveAccessMode does: mode must be ReadWriteMany, and for a CSI-backed PV
+// that requires an actually-installed driver rather than just an RWX-capable one, since an
+// uninstalled driver can't be trusted to support multi-node attach regardless of what the
+// PV claims. A lookup error is treated the same as "not found": be conservative.
+func (r *VolumeResolver) resolveMigratable(pv *k8sv1.PersistentVolume, mode k8sv1.PersistentVolumeAccessMode) bool {
+	if mode != k8sv1.ReadWriteMany {
+		return false
+	}
+	if pv.Spec.CSI == nil {
+		return true
+	}
+	_, found, err := r.csiDriverFromStore(pv.Spec.CSI.Driver)
+	return err == nil && found
+}
@@ -0,0 +1,69 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDedupeClaimNamesPreservesInputLengthAndOrder(t *testing.T) {
+	tests := []struct {
+		name          string
+		refs          []VolumeRef
+		expectedOrder []string
+	}{
+		{
+			name:          "no duplicates",
+			refs:          []VolumeRef{{ClaimName: "a"}, {ClaimName: "b"}, {ClaimName: "c"}},
+			expectedOrder: []string{"a", "b", "c"},
+		},
+		{
+			name:          "repeated claim keeps first-seen position",
+			refs:          []VolumeRef{{ClaimName: "a"}, {ClaimName: "b"}, {ClaimName: "a"}},
+			expectedOrder: []string{"a", "b"},
+		},
+		{
+			name:          "every ref identical",
+			refs:          []VolumeRef{{ClaimName: "a"}, {ClaimName: "a"}, {ClaimName: "a"}},
+			expectedOrder: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, firstIndex := dedupeClaimNames(tt.refs)
+			if !reflect.DeepEqual(order, tt.expectedOrder) {
+				t.Fatalf("order = %v, want %v", order, tt.expectedOrder)
+			}
+			// A caller that zips refs with a len(order)-sized result by firstIndex must
+			// get exactly len(refs) entries back, one per input ref.
+			reconstructed := make([]string, len(tt.refs))
+			for i, ref := range tt.refs {
+				reconstructed[i] = order[firstIndex[ref.ClaimName]]
+			}
+			for i, ref := range tt.refs {
+				if reconstructed[i] != ref.ClaimName {
+					t.Fatalf("reconstructed[%d] = %q, want %q (original ref)", i, reconstructed[i], ref.ClaimName)
+				}
+			}
+		})
+	}
+}
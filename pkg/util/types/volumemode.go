@@ -0,0 +1,82 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+// DefaultVolumeModeAnnotation lets a StorageClass declare which VolumeMode KubeVirt
+// should assume a PVC will bind as when the PVC itself leaves VolumeMode unset,
+// mirroring how the Kubernetes setdefault admission plugin materializes a default at
+// bind time.
+const DefaultVolumeModeAnnotation string = "kubevirt.io/default-volume-mode"
+
+// IsPVCBlockResolved determines whether pvc will end up Block-mode, handling the case
+// where pvc.Spec.VolumeMode is nil. isPVCBlock alone answers "false" for an unset
+// VolumeMode, which is wrong once the PVC actually binds: the bound PV's VolumeMode, or,
+// while still Pending, the referenced StorageClass's defaulting (or, lacking that, the
+// cluster-wide default passed in as clusterDefaultVolumeMode), can still resolve it to
+// Block. authoritative reports whether the returned answer is certain (VolumeMode was
+// set directly on the PVC, or the PVC names a bound PV, whose VolumeMode decides it and
+// whose unset VolumeMode is reported as not Block) or only a provisional best guess (derived from
+// StorageClass or cluster defaulting before the PVC has bound).
+func IsPVCBlockResolved(ctx context.Context, client kubecli.KubevirtClient, pvc *k8sv1.PersistentVolumeClaim, clusterDefaultVolumeMode *k8sv1.PersistentVolumeMode) (isBlock bool, authoritative bool, err error) {
+	if pvc.Spec.VolumeMode != nil {
+		return isPVCBlock(pvc), true, nil
+	}
+
+	if pvc.Spec.VolumeName != "" {
+		pv, err := client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, v1.GetOptions{})
+		if err != nil {
+			return false, false, err
+		}
+		if pv.Spec.VolumeMode != nil {
+			return *pv.Spec.VolumeMode == k8sv1.PersistentVolumeBlock, true, nil
+		}
+		return false, true, nil
+	}
+
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		sc, err := client.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, v1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return false, false, err
+		}
+		if err == nil {
+			if mode, ok := sc.Annotations[DefaultVolumeModeAnnotation]; ok {
+				return mode == string(k8sv1.PersistentVolumeBlock), false, nil
+			}
+		}
+	}
+
+	// No per-StorageClass annotation to go on; fall back to the cluster-wide default a
+	// KubeVirt admin can configure centrally instead of annotating every StorageClass.
+	if clusterDefaultVolumeMode != nil {
+		return *clusterDefaultVolumeMode == k8sv1.PersistentVolumeBlock, false, nil
+	}
+
+	return false, false, nil
+}
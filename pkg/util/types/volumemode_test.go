@@ -0,0 +1,161 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2024 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	k8sv1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"kubevirt.io/client-go/kubecli"
+)
+
+func newVolumeModeMockClient(t *testing.T, objects ...runtime.Object) kubecli.KubevirtClient {
+	ctrl := gomock.NewController(t)
+	kubeClient := fake.NewSimpleClientset(objects...)
+	virtClient := kubecli.NewMockKubevirtClient(ctrl)
+	virtClient.EXPECT().CoreV1().Return(kubeClient.CoreV1()).AnyTimes()
+	virtClient.EXPECT().StorageV1().Return(kubeClient.StorageV1()).AnyTimes()
+	return virtClient
+}
+
+func blockMode() *k8sv1.PersistentVolumeMode {
+	mode := k8sv1.PersistentVolumeBlock
+	return &mode
+}
+
+func ptrString(s string) *string {
+	return &s
+}
+
+func TestIsPVCBlockResolved(t *testing.T) {
+	tests := []struct {
+		name                     string
+		pvc                      *k8sv1.PersistentVolumeClaim
+		pv                       *k8sv1.PersistentVolume
+		sc                       *storagev1.StorageClass
+		clusterDefaultVolumeMode *k8sv1.PersistentVolumeMode
+		wantBlock                bool
+		wantAuthoritative        bool
+	}{
+		{
+			name: "VolumeMode set directly on the PVC",
+			pvc: &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a"},
+				Spec:       k8sv1.PersistentVolumeClaimSpec{VolumeMode: blockMode()},
+			},
+			wantBlock:         true,
+			wantAuthoritative: true,
+		},
+		{
+			name: "VolumeMode resolved from the bound PV",
+			pvc: &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a"},
+				Spec:       k8sv1.PersistentVolumeClaimSpec{VolumeName: "pv-a"},
+			},
+			pv: &k8sv1.PersistentVolume{
+				ObjectMeta: v1.ObjectMeta{Name: "pv-a"},
+				Spec:       k8sv1.PersistentVolumeSpec{VolumeMode: blockMode()},
+			},
+			wantBlock:         true,
+			wantAuthoritative: true,
+		},
+		{
+			name: "bound PV leaves VolumeMode unset",
+			pvc: &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a"},
+				Spec:       k8sv1.PersistentVolumeClaimSpec{VolumeName: "pv-a"},
+			},
+			pv: &k8sv1.PersistentVolume{
+				ObjectMeta: v1.ObjectMeta{Name: "pv-a"},
+			},
+			wantBlock:         false,
+			wantAuthoritative: true,
+		},
+		{
+			name: "unbound PVC falls back to the StorageClass annotation",
+			pvc: &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a"},
+				Spec:       k8sv1.PersistentVolumeClaimSpec{StorageClassName: ptrString("sc-a")},
+			},
+			sc: &storagev1.StorageClass{
+				ObjectMeta: v1.ObjectMeta{Name: "sc-a", Annotations: map[string]string{DefaultVolumeModeAnnotation: string(k8sv1.PersistentVolumeBlock)}},
+			},
+			wantBlock:         true,
+			wantAuthoritative: false,
+		},
+		{
+			name: "unbound PVC references a StorageClass with no default-volume-mode annotation",
+			pvc: &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a"},
+				Spec:       k8sv1.PersistentVolumeClaimSpec{StorageClassName: ptrString("sc-a")},
+			},
+			sc:                       &storagev1.StorageClass{ObjectMeta: v1.ObjectMeta{Name: "sc-a"}},
+			clusterDefaultVolumeMode: blockMode(),
+			wantBlock:                true,
+			wantAuthoritative:        false,
+		},
+		{
+			name: "unbound PVC with no StorageClass falls back to the cluster default",
+			pvc: &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a"},
+			},
+			clusterDefaultVolumeMode: blockMode(),
+			wantBlock:                true,
+			wantAuthoritative:        false,
+		},
+		{
+			name: "unbound PVC, no StorageClass, no cluster default",
+			pvc: &k8sv1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "claim-a"},
+			},
+			wantBlock:         false,
+			wantAuthoritative: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			if tt.pv != nil {
+				objects = append(objects, tt.pv)
+			}
+			if tt.sc != nil {
+				objects = append(objects, tt.sc)
+			}
+			client := newVolumeModeMockClient(t, objects...)
+
+			gotBlock, gotAuthoritative, err := IsPVCBlockResolved(context.Background(), client, tt.pvc, tt.clusterDefaultVolumeMode)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotBlock != tt.wantBlock || gotAuthoritative != tt.wantAuthoritative {
+				t.Fatalf("IsPVCBlockResolved() = (%v, %v), want (%v, %v)", gotBlock, gotAuthoritative, tt.wantBlock, tt.wantAuthoritative)
+			}
+		})
+	}
+}